@@ -72,7 +72,7 @@ func main() {
 
 	// Summary Counting
 	for _, phone := range phoneNumbers {
-		c, _ := client.Get(context.Background(), fmt.Sprintf("message_gateway:counter:%s", phone)).Int64()
+		c, _ := client.Get(context.Background(), rr.CounterKey(phone)).Int64()
 		fmt.Printf("%s - %d\n", phone, c)
 	}
 
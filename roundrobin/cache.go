@@ -0,0 +1,302 @@
+package roundrobin
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultCachePollInterval is how often the fallback poller resyncs the
+// whole snapshot, for Redis deployments where notify-keyspace-events
+// isn't enabled and keyspace notifications never arrive.
+const defaultCachePollInterval = 5 * time.Second
+
+// reserveScriptSource atomically reserves phone for the caller, but only
+// if the round-robin cursor is still where the cache believed it to be,
+// the phone hasn't been locked out since the cache was read, and it
+// still has rate-limit tokens available. This is the one Redis round
+// trip GetNextPhoneNumber needs once candidates are served from the
+// local cache instead of ZCARD/ZRANGEBYSCORE/N*EXISTS. The cache only
+// tracks lock state locally (see handleEvent) - rate-limit state is
+// refilled continuously, so it's checked and consumed here rather than
+// mirrored into the cache, the same way candidate() never claims to
+// know about it.
+//
+// The three outcomes are distinguished (see the reserve* constants
+// below) because getNextCached must react differently to each: a stale
+// cursor or lock means the cache itself is out of date and needs a
+// refresh before retrying, while a rate-limit denial means the cache's
+// view of phone is still accurate - it's simply this phone's turn to be
+// skipped in favor of the next candidate, exactly like a locked one.
+//
+// KEYS[1] = lastUsedIndexKey
+// KEYS[2] = lockLimitedPrefix
+// KEYS[3] = counterPrefix
+// KEYS[4] = tokenBucketPrefix
+// ARGV[1] = expected current value of lastUsedIndexKey ("" if cache has none)
+// ARGV[2] = phone being reserved
+// ARGV[3] = phone's score, to become the new lastUsedIndexKey
+const reserveScriptSource = rateLimitCheckSource + `
+local lastUsedIndexKey = KEYS[1]
+local lockLimitedPrefix = KEYS[2]
+local counterPrefix = KEYS[3]
+local tokenBucketPrefix = KEYS[4]
+local expected = ARGV[1]
+local phone = ARGV[2]
+local newScore = ARGV[3]
+
+local current = redis.call('GET', lastUsedIndexKey)
+if expected == '' then
+	if current ~= false then
+		return 0
+	end
+elseif current ~= expected then
+	return 0
+end
+
+if redis.call('EXISTS', lockLimitedPrefix .. phone) == 1 then
+	return 0
+end
+
+if not rateLimitAllows(tokenBucketPrefix, phone) then
+	return 2
+end
+rateLimitConsume(tokenBucketPrefix, phone)
+
+redis.call('SET', lastUsedIndexKey, newScore)
+redis.call('INCR', counterPrefix .. phone)
+return 1
+`
+
+var reserveScript = redis.NewScript(reserveScriptSource)
+
+// reserveScript's possible return values.
+const (
+	reserveStale       = 0 // cursor moved or phone locked since the cache was read; refresh and retry
+	reserveReserved    = 1 // phone is reserved
+	reserveRateLimited = 2 // phone is out of rate-limit tokens; skip it like a locked one, no refresh needed
+)
+
+// Cache keeps an in-process snapshot of the phone number set, the
+// per-phone lock state and the round-robin cursor, refreshed on startup
+// and kept fresh via a subscription to Redis keyspace notifications. A
+// fallback poller resyncs the whole snapshot periodically in case
+// notify-keyspace-events isn't enabled on the server.
+type Cache struct {
+	client redis.UniversalClient
+	db     int
+
+	phoneNumbersKey  string
+	lockPrefix       string
+	lastUsedIndexKey string
+	keyspacePrefix   string
+	pollInterval     time.Duration
+
+	mu           sync.RWMutex
+	numbers      []redis.Z
+	locked       map[string]bool
+	lastScoreStr string
+	lastScoreNum float64
+
+	cancel context.CancelFunc
+}
+
+func newCache(client redis.UniversalClient, phoneNumbersKey, lockPrefix, lastUsedIndexKey, keyspacePrefix string, db int) *Cache {
+	return &Cache{
+		client:           client,
+		db:               db,
+		phoneNumbersKey:  phoneNumbersKey,
+		lockPrefix:       lockPrefix,
+		lastUsedIndexKey: lastUsedIndexKey,
+		keyspacePrefix:   keyspacePrefix,
+		pollInterval:     defaultCachePollInterval,
+		locked:           make(map[string]bool),
+		lastScoreNum:     -1,
+	}
+}
+
+// Start loads the initial snapshot and spawns the background goroutines
+// that keep it fresh until ctx is done or Stop is called.
+func (c *Cache) Start(ctx context.Context) error {
+	if err := c.refresh(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+
+	go c.watch(ctx)
+	go c.pollLoop(ctx)
+
+	return nil
+}
+
+// Stop ends the background refresh goroutines.
+func (c *Cache) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// refresh reloads the phone list, every phone's lock state and the
+// round-robin cursor from Redis in one pass.
+func (c *Cache) refresh(ctx context.Context) error {
+	numbers, err := c.client.ZRangeWithScores(ctx, c.phoneNumbersKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	locked := make(map[string]bool, len(numbers))
+	for _, z := range numbers {
+		phone := z.Member.(string)
+		exists, err := c.client.Exists(ctx, c.lockPrefix+phone).Result()
+		if err != nil {
+			return err
+		}
+		locked[phone] = exists == 1
+	}
+
+	lastScoreStr, lastScoreNum, err := c.readLastScore(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.numbers = numbers
+	c.locked = locked
+	c.lastScoreStr = lastScoreStr
+	c.lastScoreNum = lastScoreNum
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Cache) readLastScore(ctx context.Context) (string, float64, error) {
+	str, err := c.client.Get(ctx, c.lastUsedIndexKey).Result()
+	if err == redis.Nil {
+		return "", -1, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	num, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	return str, num, nil
+}
+
+// candidate picks the next phone the cache believes is available,
+// wrapping around to the beginning if nothing unlocked remains ahead of
+// the cursor, along with the expected-current-value string the caller
+// must present to reserveScript. excluded skips phones already tried and
+// rejected for a reason the cache can't see locally (e.g. out of
+// rate-limit tokens) within this GetNextPhoneNumber call.
+func (c *Cache) candidate(excluded map[string]bool) (phone string, score float64, expected string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, z := range c.numbers {
+		phone := z.Member.(string)
+		if z.Score > c.lastScoreNum && !c.locked[phone] && !excluded[phone] {
+			return phone, z.Score, c.lastScoreStr, true
+		}
+	}
+	for _, z := range c.numbers {
+		phone := z.Member.(string)
+		if !c.locked[phone] && !excluded[phone] {
+			return phone, z.Score, c.lastScoreStr, true
+		}
+	}
+
+	return "", 0, "", false
+}
+
+// markReserved records a successful reservation locally, so the next
+// candidate() call advances the cursor without waiting for a
+// notification.
+func (c *Cache) markReserved(phone string, score float64) {
+	c.mu.Lock()
+	c.lastScoreNum = score
+	c.lastScoreStr = scoreString(score)
+	c.mu.Unlock()
+}
+
+func (c *Cache) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = c.refresh(ctx)
+		}
+	}
+}
+
+// watch subscribes to keyspace notifications for this pool's keys
+// (requires notify-keyspace-events including K, and at least the
+// relevant event classes, e.g. "KEA") and updates the snapshot as events
+// arrive, without a round trip for the common cases.
+func (c *Cache) watch(ctx context.Context) {
+	channelPrefix := "__keyspace@" + strconv.Itoa(c.db) + "__:"
+	pubsub := c.client.PSubscribe(ctx, channelPrefix+c.keyspacePrefix+"*")
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			key := strings.TrimPrefix(msg.Channel, channelPrefix)
+			c.handleEvent(ctx, key, msg.Payload)
+		}
+	}
+}
+
+// handleEvent only tracks membership, lock and cursor state locally;
+// token-bucket keys are deliberately not mirrored here since they refill
+// continuously, so events on them are a no-op and reserveScript checks
+// the bucket directly on every reservation instead.
+func (c *Cache) handleEvent(ctx context.Context, key, event string) {
+	switch {
+	case key == c.phoneNumbersKey:
+		// Membership changed; the candidate set itself needs a real
+		// resync rather than a local flag flip.
+		_ = c.refresh(ctx)
+
+	case strings.HasPrefix(key, c.lockPrefix):
+		phone := strings.TrimPrefix(key, c.lockPrefix)
+		switch event {
+		case "expired", "del":
+			c.mu.Lock()
+			c.locked[phone] = false
+			c.mu.Unlock()
+		case "set":
+			c.mu.Lock()
+			c.locked[phone] = true
+			c.mu.Unlock()
+		}
+
+	case key == c.lastUsedIndexKey && event == "set":
+		if str, num, err := c.readLastScore(ctx); err == nil {
+			c.mu.Lock()
+			c.lastScoreStr = str
+			c.lastScoreNum = num
+			c.mu.Unlock()
+		}
+	}
+}
+
+func scoreString(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,34 @@
+package roundrobin
+
+// defaultNamespace is the hash tag wrapped around every key a RoundRobin
+// instance creates. Keeping all keys under one hash tag pins them to the
+// same Redis Cluster slot, so the multi-key Lua scripts in this package
+// never fail with CROSSSLOT.
+const defaultNamespace = "message_gateway"
+
+// WithKeyNamespace sets the hash tag used for every key this instance
+// creates, in place of the default "message_gateway". Use a distinct
+// namespace per tenant to run multiple independent pools against one
+// Redis (or Cluster) without their keys colliding.
+func WithKeyNamespace(namespace string) Option {
+	return func(r *RoundRobin) {
+		r.namespace = namespace
+	}
+}
+
+// tag returns this instance's hash tag, e.g. "{message_gateway}".
+func (r *RoundRobin) tag() string {
+	return "{" + r.namespace + "}"
+}
+
+// key builds a namespaced, hash-tagged key, e.g. key("phone_numbers") ->
+// "{message_gateway}:phone_numbers".
+func (r *RoundRobin) key(suffix string) string {
+	return r.tag() + ":" + suffix
+}
+
+// CounterKey returns the key holding number's usage counter, namespaced
+// consistently with this instance's other keys.
+func (r *RoundRobin) CounterKey(number string) string {
+	return r.counterKeyPrefix + number
+}
@@ -0,0 +1,146 @@
+package roundrobin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotObtained is returned by Locker.Obtain when the lock could not be
+// acquired before the retry strategy gave up.
+var ErrNotObtained = errors.New("roundrobin: lock not obtained")
+
+// ErrLockNotHeld is returned by Lock.Release and Lock.Refresh when the
+// lock key no longer holds this lock's token, e.g. because it expired
+// and was since obtained by another process.
+var ErrLockNotHeld = errors.New("roundrobin: lock not held")
+
+// releaseScript deletes the lock key only if it still holds our token,
+// so a process can never release a lock it doesn't own.
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+else
+	return 0
+end
+`)
+
+// refreshScript extends the lock's TTL only if it still holds our token.
+var refreshScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`)
+
+// Locker obtains distributed locks backed by Redis. Each lock is a random
+// token stored under the lock key with SET NX PX, and every release or
+// refresh is a Lua compare-and-swap against that token, so a lease that
+// outlives its TTL can be taken over by another process without either
+// side corrupting the other's lock.
+type Locker struct {
+	client   redis.Cmdable
+	fenceKey string
+}
+
+// NewLocker creates a Locker backed by client. fenceKey holds the shared
+// counter every Lock's fencing token is drawn from.
+func NewLocker(client redis.Cmdable, fenceKey string) *Locker {
+	return &Locker{client: client, fenceKey: fenceKey}
+}
+
+// Lock represents a held lease on a key, identified by a random token
+// only this holder knows.
+type Lock struct {
+	client redis.Cmdable
+	key    string
+	token  string
+	fence  int64
+}
+
+// Key returns the Redis key this lock was obtained on.
+func (l *Lock) Key() string { return l.key }
+
+// Fence returns this lock's fencing token. Fencing tokens increase
+// monotonically across every lock ever obtained via the same Locker, so
+// a downstream resource can reject writes from a holder presenting a
+// stale (lower) token.
+func (l *Lock) Fence() int64 { return l.fence }
+
+// Obtain acquires a lock on key, leased for ttl. strategy controls
+// whether and how Obtain retries after a failed attempt; pass nil to
+// default to NoRetry. It returns ErrNotObtained if the lock could not be
+// acquired before strategy gave up.
+func (lk *Locker) Obtain(ctx context.Context, key string, ttl time.Duration, strategy RetryStrategy) (*Lock, error) {
+	if strategy == nil {
+		strategy = NoRetry()
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ok, err := lk.client.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fence, err := lk.client.Incr(ctx, lk.fenceKey).Result()
+			if err != nil {
+				return nil, err
+			}
+			return &Lock{client: lk.client, key: key, token: token, fence: fence}, nil
+		}
+
+		backoff := strategy.NextBackoff()
+		if backoff <= 0 {
+			return nil, ErrNotObtained
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// Release gives up the lock. It is a no-op error (ErrLockNotHeld) if the
+// lock already expired and was taken over by another holder.
+func (l *Lock) Release(ctx context.Context) error {
+	res, err := releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh extends the lock's TTL, provided it hasn't already expired and
+// been taken over by another holder.
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	res, err := refreshScript.Run(ctx, l.client, []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
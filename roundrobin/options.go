@@ -0,0 +1,31 @@
+package roundrobin
+
+// Strategy selects the algorithm GetNextPhoneNumber uses to pick the next
+// phone number.
+type Strategy int
+
+const (
+	// StrategyRoundRobin cycles through phone numbers in score order,
+	// giving each an equal share. This is the default.
+	StrategyRoundRobin Strategy = iota
+	// StrategyWeighted picks a phone at random, proportional to its
+	// configured weight.
+	StrategyWeighted
+	// StrategySmoothWeighted interleaves phones according to their
+	// weight using the Nginx smooth weighted round-robin algorithm, so
+	// heavier phones are spread evenly rather than handed out in bursts.
+	StrategySmoothWeighted
+	// StrategyLeastUsed picks the non-locked phone with the smallest
+	// usage counter.
+	StrategyLeastUsed
+)
+
+// Option configures a RoundRobin instance.
+type Option func(*RoundRobin)
+
+// WithStrategy selects the algorithm used by GetNextPhoneNumber.
+func WithStrategy(s Strategy) Option {
+	return func(r *RoundRobin) {
+		r.strategy = s
+	}
+}
@@ -0,0 +1,240 @@
+package roundrobin
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitCheckSource is a Lua helper shared by every selection script.
+// It implements a token bucket keyed by prefix..phone: capacity and
+// refill (tokens/sec) are configured once via SetPhoneNumberRate and
+// stored alongside the bucket's live state, so a phone with no
+// configured capacity is always allowed. It's inlined into each
+// selection script (selectScriptSource, the weighted-strategy scripts
+// and reserveScriptSource) since Redis has no cross-script function
+// sharing short of the newer FUNCTION LOAD library feature.
+//
+// rateLimitAllows only peeks at the projected token count, so it's safe
+// to call once per candidate while scanning for one to pick.
+// rateLimitConsume does the actual debit and must be called exactly
+// once, for the phone that was finally selected - never as a filter
+// predicate, or every candidate examined would drain a token.
+const rateLimitCheckSource = `
+local function rateLimitProject(prefix, phone)
+	local key = prefix .. phone
+	local cfg = redis.call('HMGET', key, 'capacity', 'refill', 'tokens', 'last_refill_ms')
+	local capacity = tonumber(cfg[1])
+	if capacity == nil then
+		return nil, nil, nil
+	end
+
+	local refill = tonumber(cfg[2]) or 0
+	local tokens = tonumber(cfg[3])
+	local lastRefill = tonumber(cfg[4])
+
+	local time = redis.call('TIME')
+	local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+	if tokens == nil then
+		tokens = capacity
+	end
+	if lastRefill == nil then
+		lastRefill = now
+	end
+
+	local elapsed = now - lastRefill
+	if elapsed > 0 then
+		tokens = math.min(capacity, tokens + elapsed * refill / 1000)
+	end
+
+	return tokens, refill, now
+end
+
+local function rateLimitAllows(prefix, phone)
+	local tokens = rateLimitProject(prefix, phone)
+	if tokens == nil then
+		return true
+	end
+	return tokens >= 1
+end
+
+local function rateLimitConsume(prefix, phone)
+	local tokens, _, now = rateLimitProject(prefix, phone)
+	if tokens == nil then
+		return
+	end
+
+	local key = prefix .. phone
+	if tokens >= 1 then
+		redis.call('HSET', key, 'tokens', tokens - 1, 'last_refill_ms', now)
+	else
+		redis.call('HSET', key, 'tokens', tokens, 'last_refill_ms', now)
+	end
+end
+`
+
+// slidingWindowScriptSource implements a sliding-window-log rate limiter:
+// it drops timestamps older than the window, and admits the call only if
+// fewer than limit remain. Unlike the token bucket, this algorithm is
+// standalone only - it's reachable via AllowSlidingWindow but is not
+// consulted by GetNextPhoneNumber's selection scripts, so there's no
+// per-phone way to choose it for the rotation and a phone failing it is
+// not skipped like a locked one. Use the token bucket (SetPhoneNumberRate)
+// for rotation-integrated limiting; call AllowSlidingWindow directly
+// wherever burst-shaping alone is needed. Each admitted call needs a
+// unique zset member
+// even when two calls land in the same millisecond, so the timestamp is
+// paired with a monotonic sequence number rather than math.random() -
+// Redis reseeds the Lua PRNG to a constant at the start of every script
+// invocation, so two such calls would otherwise produce the identical
+// member and the second ZADD would silently overwrite the first instead
+// of adding a second entry, undercounting ZCARD and admitting more than
+// limit per window.
+//
+// KEYS[1] = slidingWindowKeyPrefix .. phone
+// ARGV[1] = limit
+// ARGV[2] = window in milliseconds
+const slidingWindowScriptSource = `
+local key = KEYS[1]
+local seqKey = key .. ':seq'
+local limit = tonumber(ARGV[1])
+local windowMs = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - windowMs)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+
+local seq = redis.call('INCR', seqKey)
+redis.call('PEXPIRE', seqKey, windowMs)
+
+redis.call('ZADD', key, now, now .. '-' .. seq)
+redis.call('PEXPIRE', key, windowMs)
+return 1
+`
+
+var slidingWindowScript = redis.NewScript(slidingWindowScriptSource)
+
+// tokenBucketScriptSource evaluates the token bucket at key the same way
+// rateLimitCheckSource's selection-script helper does, but as a
+// standalone, directly callable entry point: it consumes a token if one
+// is available, and otherwise reports how long the caller should wait
+// for the next one.
+//
+// KEYS[1] = the bucket's key (e.g. RoundRobin.TokenBucketKey(number))
+//
+// Returns {allowed (0/1), retryAfterMs}.
+const tokenBucketScriptSource = `
+local key = KEYS[1]
+local cfg = redis.call('HMGET', key, 'capacity', 'refill', 'tokens', 'last_refill_ms')
+local capacity = tonumber(cfg[1])
+if capacity == nil then
+	return {1, 0}
+end
+
+local refill = tonumber(cfg[2]) or 0
+local tokens = tonumber(cfg[3])
+local lastRefill = tonumber(cfg[4])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+if tokens == nil then
+	tokens = capacity
+end
+if lastRefill == nil then
+	lastRefill = now
+end
+
+local elapsed = now - lastRefill
+if elapsed > 0 then
+	tokens = math.min(capacity, tokens + elapsed * refill / 1000)
+end
+
+if tokens >= 1 then
+	redis.call('HSET', key, 'tokens', tokens - 1, 'last_refill_ms', now)
+	return {1, 0}
+end
+
+redis.call('HSET', key, 'tokens', tokens, 'last_refill_ms', now)
+
+local retryAfterMs = 0
+if refill > 0 then
+	retryAfterMs = math.ceil((1 - tokens) / refill * 1000)
+end
+return {0, retryAfterMs}
+`
+
+var tokenBucketScript = redis.NewScript(tokenBucketScriptSource)
+
+// RateLimiter guards per-phone send rates with either a token bucket or
+// a sliding-window log, each evaluated atomically in a single Lua call.
+// Only the token bucket (SetPhoneNumberRate, Allow) is integrated into
+// GetNextPhoneNumber's selection scripts; AllowSlidingWindow is a
+// standalone check a caller invokes itself and is never consulted
+// during phone selection.
+type RateLimiter struct {
+	client redis.Cmdable
+}
+
+// NewRateLimiter creates a RateLimiter backed by client.
+func NewRateLimiter(client redis.Cmdable) *RateLimiter {
+	return &RateLimiter{client: client}
+}
+
+// AllowSlidingWindow reports whether key is still under limit calls
+// within window, recording this call if so. It's a standalone check,
+// independent of GetNextPhoneNumber's selection - unlike the token
+// bucket, a phone failing it is not automatically skipped like a
+// locked one.
+func (rl *RateLimiter) AllowSlidingWindow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	res, err := slidingWindowScript.Run(ctx, rl.client, []string{key}, limit, window.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// Allow reports whether the token bucket at key (configured via
+// SetPhoneNumberRate, e.g. RoundRobin.TokenBucketKey(number)) has a
+// token available, consuming it if so. If not, it also returns how long
+// the caller should wait before the next token would be available. A
+// key with no configured bucket is always allowed.
+func (rl *RateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	res, err := tokenBucketScript.Run(ctx, rl.client, []string{key}).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values := res.([]interface{})
+	allowed := values[0].(int64) == 1
+	retryAfterMs := values[1].(int64)
+	return allowed, time.Duration(retryAfterMs) * time.Millisecond, nil
+}
+
+// SetPhoneNumberRate configures a token-bucket rate limit for number:
+// capacity is the bucket size and refillPerSec is how many tokens are
+// added back per second. GetNextPhoneNumber skips a phone that has run
+// out of tokens exactly as it would a locked one. A phone with no
+// configured rate is never limited.
+func (r *RoundRobin) SetPhoneNumberRate(ctx context.Context, number string, capacity int, refillPerSec float64) error {
+	key := r.tokenBucketKeyPrefix + number
+	return r.client.HSet(ctx, key, "capacity", capacity, "refill", refillPerSec).Err()
+}
+
+// SlidingWindowKey returns the key AllowSlidingWindow should rate-limit
+// number against, namespaced consistently with this instance's other keys.
+func (r *RoundRobin) SlidingWindowKey(number string) string {
+	return r.slidingWindowKeyPrefix + number
+}
+
+// TokenBucketKey returns the key RateLimiter.Allow should evaluate
+// number's token bucket against, namespaced consistently with this
+// instance's other keys.
+func (r *RoundRobin) TokenBucketKey(number string) string {
+	return r.tokenBucketKeyPrefix + number
+}
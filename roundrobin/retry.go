@@ -0,0 +1,71 @@
+package roundrobin
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryStrategy controls how Locker.Obtain waits between failed lock
+// attempts. NextBackoff is called once per failed attempt; returning a
+// non-positive duration tells Obtain to stop retrying.
+type RetryStrategy interface {
+	NextBackoff() time.Duration
+}
+
+type noRetry struct{}
+
+// NoRetry gives up immediately after the first failed attempt.
+func NoRetry() RetryStrategy { return noRetry{} }
+
+func (noRetry) NextBackoff() time.Duration { return 0 }
+
+type linearBackoff time.Duration
+
+// LinearBackoff retries forever (until ctx is done), waiting d between
+// each attempt.
+func LinearBackoff(d time.Duration) RetryStrategy { return linearBackoff(d) }
+
+func (l linearBackoff) NextBackoff() time.Duration { return time.Duration(l) }
+
+type exponentialBackoff struct {
+	min, max time.Duration
+	attempt  int
+}
+
+// ExponentialBackoff doubles the wait time after every failed attempt,
+// starting at min and capping at max, with up to 50% jitter to avoid
+// thundering-herd retries across processes.
+func ExponentialBackoff(min, max time.Duration) RetryStrategy {
+	return &exponentialBackoff{min: min, max: max}
+}
+
+func (e *exponentialBackoff) NextBackoff() time.Duration {
+	e.attempt++
+	backoff := e.min * time.Duration(math.Pow(2, float64(e.attempt-1)))
+	if backoff <= 0 || backoff > e.max {
+		backoff = e.max
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+	return backoff/2 + jitter
+}
+
+type withMaxRetries struct {
+	strategy RetryStrategy
+	max      int
+	attempt  int
+}
+
+// WithMaxRetries wraps another strategy so Obtain gives up after max
+// retries (max+1 failed attempts in all) instead of retrying indefinitely.
+func WithMaxRetries(strategy RetryStrategy, max int) RetryStrategy {
+	return &withMaxRetries{strategy: strategy, max: max}
+}
+
+func (w *withMaxRetries) NextBackoff() time.Duration {
+	if w.attempt >= w.max {
+		return 0
+	}
+	w.attempt++
+	return w.strategy.NextBackoff()
+}
@@ -3,35 +3,54 @@ package roundrobin
 import (
 	"context"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-const (
-	defaultPhoneNumbersKey    = "message_gateway:phone_numbers"       // Sorted set storing phone numbers with their scores
-	defaultLockKey            = "message_gateway:lock"                // Lock key for ensuring atomic operations
-	lastUsedIndexKey          = "message_gateway:last_used_index"     // Key to store the last used index
-	counterKeyPrefix          = "message_gateway:counter:"            // Prefix for phone number counter keys
-	phoneLockKeyPrefix        = "message_gateway:phone_lock:"         // Prefix for phone number lock keys
-	phoneLockLimitedKeyPrefix = "message_gateway:phone_lock_limited:" // Prefix for phone number lock keys
-)
-
 // RoundRobin handles the round-robin selection of phone numbers
 type RoundRobin struct {
-	client          *redis.Client
-	phoneNumbersKey string
-	lockKey         string
+	client    redis.UniversalClient
+	locker    *Locker
+	cache     *Cache
+	namespace string
+	strategy  Strategy
+
+	phoneNumbersKey           string
+	lastUsedIndexKey          string
+	counterKeyPrefix          string
+	phoneLockLimitedKeyPrefix string
+	weightsKey                string
+	currentWeightsKey         string
+	tokenBucketKeyPrefix      string
+	slidingWindowKeyPrefix    string
 }
 
-// New creates a new RoundRobin instance
-func New(client *redis.Client) *RoundRobin {
-	return &RoundRobin{
-		client:          client,
-		phoneNumbersKey: defaultPhoneNumbersKey,
-		lockKey:         defaultLockKey,
+// New creates a new RoundRobin instance. client may be a *redis.Client, a
+// *redis.ClusterClient or a *redis.Ring, since every key this package
+// builds is hash-tagged to land on one slot.
+func New(client redis.UniversalClient, opts ...Option) *RoundRobin {
+	r := &RoundRobin{
+		client:    client,
+		namespace: defaultNamespace,
+		strategy:  StrategyRoundRobin,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+
+	r.phoneNumbersKey = r.key("phone_numbers")
+	r.lastUsedIndexKey = r.key("last_used_index")
+	r.counterKeyPrefix = r.key("counter") + ":"
+	r.phoneLockLimitedKeyPrefix = r.key("phone_lock_limited") + ":"
+	r.weightsKey = r.key("weights")
+	r.currentWeightsKey = r.key("cw")
+	r.tokenBucketKeyPrefix = r.key("tb") + ":"
+	r.slidingWindowKeyPrefix = r.key("rl") + ":"
+
+	r.locker = NewLocker(client, r.key("fence"))
+
+	return r
 }
 
 // AddPhoneNumber adds a new phone number to the pool
@@ -75,9 +94,14 @@ func (r *RoundRobin) SetPhoneNumberLockLimited(ctx context.Context, number strin
 		return err
 	}
 
-	// Set lock with TTL
-	lockLimitedKey := phoneLockLimitedKeyPrefix + number
-	return r.client.SetNX(ctx, lockLimitedKey, "1", ttl).Err()
+	// Obtain a (non-retried) lock with TTL; we never release it
+	// explicitly, letting it expire naturally once ttl elapses.
+	lockLimitedKey := r.phoneLockLimitedKeyPrefix + number
+	_, err = r.locker.Obtain(ctx, lockLimitedKey, ttl, NoRetry())
+	if errors.Is(err, ErrNotObtained) {
+		return errors.New("phone number is already locked")
+	}
+	return err
 }
 
 // GetNextPhoneNumber returns the next available phone number using a consistent round-robin approach
@@ -91,116 +115,101 @@ func (r *RoundRobin) GetNextPhoneNumber(ctx context.Context) (string, error) {
 		return "", errors.New("no phone numbers available")
 	}
 
-	// Try to acquire lock with retries for atomic operations
-	maxRetries := 100
-	lockDuration := 10 * time.Second
-	timeDelay := 100 * time.Millisecond
+	switch r.strategy {
+	case StrategyWeighted:
+		return r.getNextWeighted(ctx)
+	case StrategySmoothWeighted:
+		return r.getNextSmoothWeighted(ctx)
+	case StrategyLeastUsed:
+		return r.getNextLeastUsed(ctx)
+	default:
+		if r.cache != nil {
+			return r.getNextCached(ctx)
+		}
+		// The whole selection happens atomically inside selectScript, so
+		// no external mutex is needed here.
+		phone, err := selectScript.Run(ctx, r.client, []string{
+			r.phoneNumbersKey,
+			r.lastUsedIndexKey,
+			r.counterKeyPrefix,
+			r.phoneLockLimitedKeyPrefix,
+			r.tokenBucketKeyPrefix,
+		}, size).Text()
+		return phoneOrNotAvailable(phone, err)
+	}
+}
+
+// maxCacheReserveAttempts bounds how many candidates getNextCached will
+// try to reserve in all, counting the first one, stale-cache retries and
+// rate-limited skips alike, before giving up.
+const maxCacheReserveAttempts = 5
+
+// getNextCached serves candidates from r.cache instead of scanning Redis,
+// and only touches Redis once per candidate: a single Lua CAS that
+// reserves the pick if the cache's view of the cursor and lock state
+// still holds and the phone still has rate-limit tokens. A stale cursor
+// or lock (another process reserved first, or locked the phone since the
+// cache was read) triggers a resync and one more attempt. A rate-limited
+// phone doesn't need a resync - the cache's view of it is still
+// accurate - so it's simply excluded and the next candidate is tried
+// immediately. Either way, getNextCached gives up after
+// maxCacheReserveAttempts.
+func (r *RoundRobin) getNextCached(ctx context.Context) (string, error) {
+	excluded := make(map[string]bool)
+
+	for attempt := 0; attempt < maxCacheReserveAttempts; attempt++ {
+		phone, score, expected, ok := r.cache.candidate(excluded)
+		if !ok {
+			return "", errors.New("no available phone numbers (all are locked)")
+		}
 
-	for i := 0; i < maxRetries; i++ {
-		locked, err := r.client.SetNX(ctx, r.lockKey, "1", lockDuration).Result()
+		reserved, err := reserveScript.Run(ctx, r.client, []string{
+			r.lastUsedIndexKey,
+			r.phoneLockLimitedKeyPrefix,
+			r.counterKeyPrefix,
+			r.tokenBucketKeyPrefix,
+		}, expected, phone, scoreString(score)).Int64()
 		if err != nil {
 			return "", err
 		}
-		if locked {
-			defer r.client.Del(ctx, r.lockKey)
-
-			// Get the last used score
-			lastScore, err := r.client.Get(ctx, lastUsedIndexKey).Float64()
-			if err == redis.Nil {
-				lastScore = -1
-			} else if err != nil {
-				return "", err
-			}
 
-			// Get all phone numbers with scores greater than last used score
-			scores, err := r.client.ZRangeByScoreWithScores(ctx, r.phoneNumbersKey, &redis.ZRangeBy{
-				Min: fmt.Sprintf("%f", lastScore),
-				Max: "+inf",
-			}).Result()
-			if err != nil {
+		switch reserved {
+		case reserveReserved:
+			r.cache.markReserved(phone, score)
+			return phone, nil
+		case reserveRateLimited:
+			excluded[phone] = true
+		default:
+			if err := r.cache.refresh(ctx); err != nil {
 				return "", err
 			}
+		}
+	}
 
-			// If no numbers found after lastScore, wrap around to the beginning
-			if len(scores) <= 1 {
-				scores, err = r.client.ZRangeByScoreWithScores(ctx, r.phoneNumbersKey, &redis.ZRangeBy{
-					Min: "-inf",
-					Max: "+inf",
-				}).Result()
-				if err != nil {
-					return "", err
-				}
-			}
-
-			// Find the next available phone number
-			var selectedPhone string
-			var selectedScore float64
-			for _, z := range scores {
-				phone := z.Member.(string)
-				score := z.Score
-
-				// Skip the current number if it's the last used one
-				if score <= lastScore {
-					continue
-				}
-
-				// Check if the phone is locked
-				lockLimitedKey := phoneLockLimitedKeyPrefix + phone
-				locked, err := r.client.Exists(ctx, lockLimitedKey).Result()
-				if err != nil {
-					return "", err
-				}
-				if locked == 0 {
-					selectedPhone = phone
-					selectedScore = score
-					break
-				}
-			}
-
-			// If no available phone found, try from the beginning
-			if selectedPhone == "" {
-				for _, z := range scores {
-					phone := z.Member.(string)
-					score := z.Score
-
-					lockLimitedKey := phoneLockLimitedKeyPrefix + phone
-					locked, err := r.client.Exists(ctx, lockLimitedKey).Result()
-					if err != nil {
-						return "", err
-					}
-					if locked == 0 {
-						selectedPhone = phone
-						selectedScore = score
-						break
-					}
-				}
-			}
-
-			// If still no available phone found
-			if selectedPhone == "" {
-				return "", errors.New("no available phone numbers (all are locked)")
-			}
-
-			// Update the last used score
-			err = r.client.Set(ctx, lastUsedIndexKey, selectedScore, 0).Err()
-			if err != nil {
-				return "", err
-			}
-
-			// Increment counter for the selected phone number
-			counterKey := counterKeyPrefix + selectedPhone
-			_, err = r.client.Incr(ctx, counterKey).Result()
-			if err != nil {
-				return "", err
-			}
+	return "", errors.New("failed to reserve a phone number after cache retries")
+}
 
-			return selectedPhone, nil
-		}
-		if i < maxRetries-1 {
-			time.Sleep(timeDelay)
-			continue
-		}
+// EnableCache starts an in-process cache of the phone list and lock
+// state, refreshed via Redis keyspace notifications (enable them with
+// e.g. `CONFIG SET notify-keyspace-events KEA`) and a periodic fallback
+// poll for deployments where they aren't configured. Once started,
+// GetNextPhoneNumber's default round-robin strategy serves candidates
+// from it instead of scanning Redis on every call. db is the logical
+// Redis database number to subscribe keyspace events for.
+func (r *RoundRobin) EnableCache(ctx context.Context, db int) error {
+	cache := newCache(r.client, r.phoneNumbersKey, r.phoneLockLimitedKeyPrefix, r.lastUsedIndexKey, r.tag()+":", db)
+	if err := cache.Start(ctx); err != nil {
+		return err
 	}
+	r.cache = cache
+	return nil
+}
 
-	return "", errors.New("failed to acquire lock after maximum retries")
+// DisableCache stops the background cache and reverts to the uncached
+// Lua-atomic selection path.
+func (r *RoundRobin) DisableCache() {
+	if r.cache != nil {
+		r.cache.Stop()
+		r.cache = nil
+	}
 }
@@ -0,0 +1,130 @@
+package roundrobin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tedjadhi/example-go-redis-round-robin/roundrobin"
+)
+
+func newTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestGetNextPhoneNumberWrapsAround(t *testing.T) {
+	ctx := context.Background()
+	rr := roundrobin.New(newTestClient(t))
+
+	for _, n := range []string{"a", "b", "c"} {
+		if err := rr.AddPhoneNumber(ctx, n); err != nil {
+			t.Fatalf("AddPhoneNumber(%s): %v", n, err)
+		}
+	}
+
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i, w := range want {
+		got, err := rr.GetNextPhoneNumber(ctx)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if got != w {
+			t.Fatalf("call %d: got %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestSmoothWeightedInterleaving(t *testing.T) {
+	ctx := context.Background()
+	rr := roundrobin.New(newTestClient(t), roundrobin.WithStrategy(roundrobin.StrategySmoothWeighted))
+
+	if err := rr.AddPhoneNumberWithWeight(ctx, "a", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := rr.AddPhoneNumberWithWeight(ctx, "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rr.AddPhoneNumberWithWeight(ctx, "c", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nginx SWRR's canonical sequence for weights {5,1,1}.
+	want := []string{"a", "a", "b", "a", "c", "a", "a"}
+	for i, w := range want {
+		got, err := rr.GetNextPhoneNumber(ctx)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if got != w {
+			t.Fatalf("call %d: got %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestTokenBucketDeniesWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	rr := roundrobin.New(client)
+	limiter := roundrobin.NewRateLimiter(client)
+
+	if err := rr.AddPhoneNumber(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rr.SetPhoneNumberRate(ctx, "a", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	key := rr.TokenBucketKey("a")
+
+	allowed, _, err := limiter.Allow(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatal("expected the first call to consume the only token")
+	}
+
+	allowed, retryAfter, err := limiter.Allow(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("expected the second call to be rate-limited before any refill")
+	}
+	if retryAfter <= 0 {
+		t.Fatalf("expected a positive retry-after once out of tokens, got %v", retryAfter)
+	}
+}
+
+func TestAllowSlidingWindowEnforcesLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := roundrobin.NewRateLimiter(newTestClient(t))
+
+	const limit = 3
+	for i := 0; i < limit; i++ {
+		allowed, err := limiter.AllowSlidingWindow(ctx, "phone", limit, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("call %d: expected to be allowed within the window's limit", i)
+		}
+	}
+
+	allowed, err := limiter.AllowSlidingWindow(ctx, "phone", limit, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("expected the call past the limit to be rejected")
+	}
+}
@@ -0,0 +1,72 @@
+package roundrobin
+
+import "github.com/redis/go-redis/v9"
+
+// selectScriptSource atomically picks the next available phone number and
+// advances the round-robin cursor in a single round trip, replacing the
+// old ZCARD -> lock -> GET -> ZRANGEBYSCORE -> N*EXISTS -> SET -> INCR
+// sequence. A candidate is skipped exactly like a locked one if it's
+// currently rate-limited; see rateLimitCheckSource.
+//
+// KEYS[1] = phoneNumbersKey (sorted set of phone -> rotation score)
+// KEYS[2] = lastUsedIndexKey (last score handed out)
+// KEYS[3] = counterPrefix (prefix of the per-phone usage counter keys)
+// KEYS[4] = lockLimitedPrefix (prefix of the per-phone lock keys)
+// KEYS[5] = tokenBucketPrefix (prefix of the per-phone rate limit hash)
+// ARGV[1] = candidate limit (the size of the phone number set is enough)
+//
+// It returns the chosen phone number, or a nil reply if every candidate
+// is locked or rate-limited.
+const selectScriptSource = rateLimitCheckSource + `
+local phoneNumbersKey = KEYS[1]
+local lastUsedIndexKey = KEYS[2]
+local counterPrefix = KEYS[3]
+local lockLimitedPrefix = KEYS[4]
+local tokenBucketPrefix = KEYS[5]
+local limit = tonumber(ARGV[1])
+
+local function available(phone)
+	if redis.call('EXISTS', lockLimitedPrefix .. phone) == 1 then
+		return false
+	end
+	return rateLimitAllows(tokenBucketPrefix, phone)
+end
+
+local function pickFrom(minScore)
+	local candidates = redis.call('ZRANGEBYSCORE', phoneNumbersKey, '(' .. minScore, '+inf', 'LIMIT', 0, limit)
+	for _, phone in ipairs(candidates) do
+		if available(phone) then
+			return phone
+		end
+	end
+	return nil
+end
+
+local lastScore = tonumber(redis.call('GET', lastUsedIndexKey))
+if lastScore == nil then
+	lastScore = -1
+end
+
+local phone = pickFrom(lastScore)
+if not phone then
+	-- Wrap around: nothing available ahead of lastScore, so scan the
+	-- whole set from the beginning.
+	phone = pickFrom(-1)
+end
+
+if not phone then
+	return nil
+end
+
+rateLimitConsume(tokenBucketPrefix, phone)
+
+local newScore = redis.call('ZSCORE', phoneNumbersKey, phone)
+redis.call('SET', lastUsedIndexKey, newScore)
+redis.call('INCR', counterPrefix .. phone)
+
+return phone
+`
+
+// selectScript is loaded once and invoked with EVALSHA; redis.Script.Run
+// transparently falls back to EVAL on a NOSCRIPT error.
+var selectScript = redis.NewScript(selectScriptSource)
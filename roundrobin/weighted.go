@@ -0,0 +1,189 @@
+package roundrobin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// smoothWeightedScriptSource implements the Nginx smooth weighted
+// round-robin algorithm: every candidate's current weight is bumped by
+// its configured weight, the highest current weight wins, and the
+// winner's current weight is brought back down by the total weight.
+// Repeating this on every call interleaves heavier phones evenly (e.g.
+// weights {5,1,1} yields a,a,b,a,c,a,a) instead of bursts of the
+// heaviest phone.
+//
+// KEYS[1] = phoneNumbersKey
+// KEYS[2] = weightsKey
+// KEYS[3] = currentWeightsKey
+// KEYS[4] = lockLimitedPrefix
+// KEYS[5] = counterPrefix
+// KEYS[6] = tokenBucketPrefix
+const smoothWeightedScriptSource = rateLimitCheckSource + `
+local phoneNumbersKey = KEYS[1]
+local weightsKey = KEYS[2]
+local cwKey = KEYS[3]
+local lockLimitedPrefix = KEYS[4]
+local counterPrefix = KEYS[5]
+local tokenBucketPrefix = KEYS[6]
+
+local members = redis.call('ZRANGE', phoneNumbersKey, 0, -1)
+local best, bestWeight, totalWeight = nil, nil, 0
+
+for _, phone in ipairs(members) do
+	if redis.call('EXISTS', lockLimitedPrefix .. phone) == 0 and rateLimitAllows(tokenBucketPrefix, phone) then
+		local weight = tonumber(redis.call('HGET', weightsKey, phone)) or 1
+		local cw = (tonumber(redis.call('HGET', cwKey, phone)) or 0) + weight
+		redis.call('HSET', cwKey, phone, cw)
+		totalWeight = totalWeight + weight
+		if best == nil or cw > bestWeight then
+			best, bestWeight = phone, cw
+		end
+	end
+end
+
+if best == nil then
+	return nil
+end
+
+rateLimitConsume(tokenBucketPrefix, best)
+redis.call('HSET', cwKey, best, bestWeight - totalWeight)
+redis.call('INCR', counterPrefix .. best)
+return best
+`
+
+// weightedScriptSource picks a candidate at random, proportional to its
+// configured weight.
+//
+// KEYS[1] = phoneNumbersKey
+// KEYS[2] = weightsKey
+// KEYS[3] = lockLimitedPrefix
+// KEYS[4] = counterPrefix
+// KEYS[5] = tokenBucketPrefix
+const weightedScriptSource = rateLimitCheckSource + `
+local phoneNumbersKey = KEYS[1]
+local weightsKey = KEYS[2]
+local lockLimitedPrefix = KEYS[3]
+local counterPrefix = KEYS[4]
+local tokenBucketPrefix = KEYS[5]
+
+local members = redis.call('ZRANGE', phoneNumbersKey, 0, -1)
+local candidates, totalWeight = {}, 0
+
+for _, phone in ipairs(members) do
+	if redis.call('EXISTS', lockLimitedPrefix .. phone) == 0 and rateLimitAllows(tokenBucketPrefix, phone) then
+		local weight = tonumber(redis.call('HGET', weightsKey, phone)) or 1
+		totalWeight = totalWeight + weight
+		table.insert(candidates, {phone, weight})
+	end
+end
+
+if totalWeight == 0 then
+	return nil
+end
+
+-- Redis reseeds the Lua PRNG to a constant at the start of every script
+-- invocation, so math.random() alone would draw the same target on
+-- every call. Reseeding from TIME (which is itself made deterministic
+-- across replicas via effects replication) gives each invocation its
+-- own draw.
+local time = redis.call('TIME')
+math.randomseed(tonumber(time[1]) * 1000000 + tonumber(time[2]))
+
+local target = math.random() * totalWeight
+local acc = 0
+local selected = candidates[#candidates][1]
+for _, c in ipairs(candidates) do
+	acc = acc + c[2]
+	if target <= acc then
+		selected = c[1]
+		break
+	end
+end
+
+rateLimitConsume(tokenBucketPrefix, selected)
+redis.call('INCR', counterPrefix .. selected)
+return selected
+`
+
+// leastUsedScriptSource picks the available phone with the smallest
+// usage counter.
+//
+// KEYS[1] = phoneNumbersKey
+// KEYS[2] = lockLimitedPrefix
+// KEYS[3] = counterPrefix
+// KEYS[4] = tokenBucketPrefix
+const leastUsedScriptSource = rateLimitCheckSource + `
+local phoneNumbersKey = KEYS[1]
+local lockLimitedPrefix = KEYS[2]
+local counterPrefix = KEYS[3]
+local tokenBucketPrefix = KEYS[4]
+
+local members = redis.call('ZRANGE', phoneNumbersKey, 0, -1)
+local best, bestCount = nil, nil
+
+for _, phone in ipairs(members) do
+	if redis.call('EXISTS', lockLimitedPrefix .. phone) == 0 and rateLimitAllows(tokenBucketPrefix, phone) then
+		local count = tonumber(redis.call('GET', counterPrefix .. phone)) or 0
+		if best == nil or count < bestCount then
+			best, bestCount = phone, count
+		end
+	end
+end
+
+if best == nil then
+	return nil
+end
+
+rateLimitConsume(tokenBucketPrefix, best)
+redis.call('INCR', counterPrefix .. best)
+return best
+`
+
+var (
+	smoothWeightedScript = redis.NewScript(smoothWeightedScriptSource)
+	weightedScript       = redis.NewScript(weightedScriptSource)
+	leastUsedScript      = redis.NewScript(leastUsedScriptSource)
+)
+
+// AddPhoneNumberWithWeight adds a new phone number to the pool with the
+// given weight, used by StrategyWeighted and StrategySmoothWeighted.
+func (r *RoundRobin) AddPhoneNumberWithWeight(ctx context.Context, number string, weight int) error {
+	if err := r.AddPhoneNumber(ctx, number); err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, r.weightsKey, number, weight).Err()
+}
+
+func (r *RoundRobin) getNextSmoothWeighted(ctx context.Context) (string, error) {
+	phone, err := smoothWeightedScript.Run(ctx, r.client, []string{
+		r.phoneNumbersKey, r.weightsKey, r.currentWeightsKey, r.phoneLockLimitedKeyPrefix, r.counterKeyPrefix, r.tokenBucketKeyPrefix,
+	}).Text()
+	return phoneOrNotAvailable(phone, err)
+}
+
+func (r *RoundRobin) getNextWeighted(ctx context.Context) (string, error) {
+	phone, err := weightedScript.Run(ctx, r.client, []string{
+		r.phoneNumbersKey, r.weightsKey, r.phoneLockLimitedKeyPrefix, r.counterKeyPrefix, r.tokenBucketKeyPrefix,
+	}).Text()
+	return phoneOrNotAvailable(phone, err)
+}
+
+func (r *RoundRobin) getNextLeastUsed(ctx context.Context) (string, error) {
+	phone, err := leastUsedScript.Run(ctx, r.client, []string{
+		r.phoneNumbersKey, r.phoneLockLimitedKeyPrefix, r.counterKeyPrefix, r.tokenBucketKeyPrefix,
+	}).Text()
+	return phoneOrNotAvailable(phone, err)
+}
+
+func phoneOrNotAvailable(phone string, err error) (string, error) {
+	if errors.Is(err, redis.Nil) {
+		return "", errors.New("no available phone numbers (all are locked)")
+	}
+	if err != nil {
+		return "", err
+	}
+	return phone, nil
+}